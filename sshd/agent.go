@@ -0,0 +1,111 @@
+package sshd
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentForwardingChannelType is the session channel request type that
+// OpenSSH clients send to ask for agent forwarding.
+const AgentForwardingChannelType = "auth-agent-req@openssh.com"
+
+// PermitAgentForwarding reports whether perm grants agent forwarding, as
+// set from an authorized_keys permit-agent-forwarding option or a
+// certificate's extension of the same name.
+func PermitAgentForwarding(perm *ssh.Permissions) bool {
+	return perm != nil && perm.Extensions["permit-agent-forwarding"] == "yes"
+}
+
+// AcceptAgentForwarding accepts newChannel as an agent forwarding channel
+// if perm grants permit-agent-forwarding, and wraps it as an agent.Agent.
+// It rejects the channel and returns an error otherwise. The session layer
+// calls this in response to an auth-agent-req@openssh.com channel request.
+func AcceptAgentForwarding(perm *ssh.Permissions, newChannel ssh.NewChannel) (agent.Agent, error) {
+	if !PermitAgentForwarding(perm) {
+		newChannel.Reject(ssh.Prohibited, "agent forwarding not permitted")
+		return nil, errors.New("sshd: agent forwarding not permitted")
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(requests)
+	return agent.NewClient(channel), nil
+}
+
+// AgentConstraints bounds what forwarded identities may be added to an
+// agent during a chat session, regardless of what the client requested.
+type AgentConstraints struct {
+	// MaxLifetime caps how long an added identity may be used, if set.
+	MaxLifetime time.Duration
+	// ConfirmBeforeUse forces confirm-before-use on every added identity.
+	ConfirmBeforeUse bool
+}
+
+// ConstrainAgent wraps a forwarded agent.Agent so that any identity added
+// through it has c applied on top of whatever the client requested.
+func ConstrainAgent(a agent.Agent, c AgentConstraints) agent.Agent {
+	return &constrainedAgent{Agent: a, constraints: c}
+}
+
+type constrainedAgent struct {
+	agent.Agent
+	constraints AgentConstraints
+}
+
+func (c *constrainedAgent) Add(key agent.AddedKey) error {
+	if c.constraints.MaxLifetime > 0 {
+		lifetime := uint32(c.constraints.MaxLifetime.Seconds())
+		if key.LifetimeSecs == 0 || key.LifetimeSecs > lifetime {
+			key.LifetimeSecs = lifetime
+		}
+	}
+	if c.constraints.ConfirmBeforeUse {
+		key.ConfirmBeforeUse = true
+	}
+	return c.Agent.Add(key)
+}
+
+// ListIdentities returns the identities available from a forwarded agent,
+// for commands like /whoami to display.
+func ListIdentities(a agent.Agent) ([]*agent.Key, error) {
+	return a.List()
+}
+
+// Session holds the per-connection state the application layer needs to
+// expose a forwarded agent to chat commands, such as /whoami listing the
+// agent's identities. SetAgent and Agent are safe to call concurrently,
+// since the channel that forwards the agent and the command that reads
+// it back run on different goroutines.
+type Session struct {
+	perm *ssh.Permissions
+
+	mu    sync.RWMutex
+	agent agent.Agent
+}
+
+// NewSession creates a Session for a connection authenticated with perm.
+func NewSession(perm *ssh.Permissions) *Session {
+	return &Session{perm: perm}
+}
+
+// SetAgent records the agent forwarded for this session, once
+// AcceptAgentForwarding has accepted the auth-agent-req@openssh.com
+// channel.
+func (s *Session) SetAgent(a agent.Agent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agent = a
+}
+
+// Agent returns the agent forwarded for this session, or nil if the
+// client never forwarded one (or wasn't permitted to).
+func (s *Session) Agent() agent.Agent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.agent
+}
@@ -0,0 +1,33 @@
+package sshd
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSessionAgentConcurrentAccess(t *testing.T) {
+	s := NewSession(&ssh.Permissions{})
+	if s.Agent() != nil {
+		t.Fatal("expected a fresh Session to have no agent")
+	}
+
+	var a agent.Agent = agent.NewKeyring()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.SetAgent(a)
+	}()
+	go func() {
+		defer wg.Done()
+		s.Agent()
+	}()
+	wg.Wait()
+
+	if s.Agent() != a {
+		t.Fatal("expected Agent to return the agent passed to SetAgent")
+	}
+}
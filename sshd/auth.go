@@ -17,12 +17,58 @@ type Auth interface {
 	Check(net.Addr, ssh.PublicKey) (bool, error)
 }
 
+// ExpiringAuth is implemented by Auth implementations that can reject a
+// key once it has passed its expiry-time, such as one that embeds
+// AuthorizedKeys.
+type ExpiringAuth interface {
+	Auth
+	Expired(key ssh.PublicKey) bool
+}
+
+// applyPermissions merges extra into base, rejecting the connection if
+// extra's "source-address" critical option does not match conn, and
+// otherwise returning base with extra's critical options and extensions
+// merged in.
+func applyPermissions(base, extra *ssh.Permissions, conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+	if extra == nil {
+		return base, nil
+	}
+	if addr, ok := extra.CriticalOptions["source-address"]; ok {
+		if err := checkSourceAddress(addr, conn.RemoteAddr()); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range extra.CriticalOptions {
+		if base.CriticalOptions == nil {
+			base.CriticalOptions = map[string]string{}
+		}
+		base.CriticalOptions[k] = v
+	}
+	for k, v := range extra.Extensions {
+		base.Extensions[k] = v
+	}
+	return base, nil
+}
+
 // MakeAuth makes an ssh.ServerConfig which performs authentication against an Auth implementation.
 func MakeAuth(auth Auth) *ssh.ServerConfig {
+	mfa, hasMFA := auth.(MFAAuth)
+
 	config := ssh.ServerConfig{
 		NoClientAuth: false,
 		// Auth-related things should be constant-time to avoid timing attacks.
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if cert, ok := key.(*ssh.Certificate); ok {
+				perm, err := checkCert(auth, conn, cert)
+				if err != nil {
+					return nil, err
+				}
+				if hasMFA && mfa.SecondFactor().Enrolled(conn.User()) {
+					perm.Extensions["mfa-required"] = "1"
+				}
+				return perm, nil
+			}
+
 			ok, err := auth.Check(conn.RemoteAddr(), key)
 			if !ok {
 				return nil, err
@@ -30,6 +76,22 @@ func MakeAuth(auth Auth) *ssh.ServerConfig {
 			perm := &ssh.Permissions{Extensions: map[string]string{
 				"pubkey": string(key.Marshal()),
 			}}
+
+			if pa, ok := auth.(PermissionsAuth); ok {
+				perm, err = applyPermissions(perm, pa.Permissions(key), conn)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if ea, ok := auth.(ExpiringAuth); ok && ea.Expired(key) {
+				return nil, errors.New("sshd: key has expired")
+			}
+			// MFA is enforced at the session layer, not here; see
+			// MFAAuth for why. Marking the extension just tells that
+			// layer a second factor is still owed.
+			if hasMFA && mfa.SecondFactor().Enrolled(conn.User()) {
+				perm.Extensions["mfa-required"] = "1"
+			}
 			return perm, nil
 		},
 		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
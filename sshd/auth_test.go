@@ -0,0 +1,132 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestMakeAuthMFADefaultClientOrder drives a real ssh.NewServerConn against
+// a real client-side handshake, in a client's default auth method order
+// (publickey before keyboard-interactive), to prove the MFA redesign in
+// MFAAuth actually completes: gating the second factor inside
+// PublicKeyCallback/KeyboardInteractiveCallback broke exactly this case,
+// since golang.org/x/crypto/ssh's ServerConn caches PublicKeyCallback's
+// result per (user, key) and never re-invokes it.
+func TestMakeAuthMFADefaultClientOrder(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	totp := NewTOTPSecondFactor()
+	totp.SetSecret("alice", secret)
+	auth := &mfaStubAuth{sf: totp}
+
+	config := MakeAuth(auth)
+	config.AddHostKey(newTestSigner(t))
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromSigner(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A real TCP loopback, not net.Pipe: the SSH version exchange has
+	// both sides write before they read, which deadlocks net.Pipe's
+	// fully synchronous, unbuffered Write.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	acceptDone := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			acceptDone <- nil
+			return
+		}
+		acceptDone <- conn
+	}()
+
+	clientSide, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientSide.Close()
+
+	serverSide := <-acceptDone
+	if serverSide == nil {
+		t.Fatal("failed to accept the test connection")
+	}
+	defer serverSide.Close()
+
+	type serverResult struct {
+		conn *ssh.ServerConn
+		err  error
+	}
+	serverDone := make(chan serverResult, 1)
+	go func() {
+		sconn, chans, reqs, err := ssh.NewServerConn(serverSide, config)
+		if err != nil {
+			serverDone <- serverResult{err: err}
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for ch := range chans {
+				ch.Reject(ssh.UnknownChannelType, "not needed for this test")
+			}
+		}()
+		serverDone <- serverResult{conn: sconn}
+	}()
+
+	clientConfig := &ssh.ClientConfig{
+		User: "alice",
+		Auth: []ssh.AuthMethod{
+			// Default OpenSSH order: publickey is tried before
+			// keyboard-interactive.
+			ssh.PublicKeys(clientSigner),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				t.Fatal("keyboard-interactive should not be attempted: publickey alone must succeed")
+				return nil, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+
+	clientConn, _, _, err := ssh.NewClientConn(clientSide, "pipe", clientConfig)
+	if err != nil {
+		t.Fatalf("expected a default-order client to authenticate via publickey alone, got: %s", err)
+	}
+	defer clientConn.Close()
+
+	result := <-serverDone
+	if result.err != nil {
+		t.Fatalf("server handshake failed: %s", result.err)
+	}
+	defer result.conn.Close()
+
+	if !MFARequired(result.conn.Permissions) {
+		t.Fatal("expected the server to still record that alice owes a second factor")
+	}
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
@@ -0,0 +1,218 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PermissionsAuth is implemented by Auth implementations that want to
+// attach OpenSSH-style critical options and extensions to a successful
+// public key authentication, such as those loaded from an authorized_keys
+// file or presented in a certificate.
+type PermissionsAuth interface {
+	Auth
+	// Permissions returns extra Permissions to merge in for key, or nil
+	// if there is nothing to add.
+	Permissions(key ssh.PublicKey) *ssh.Permissions
+}
+
+// AuthorizedKeyOptions holds the per-key options parsed from an
+// authorized_keys entry, as described in sshd(8).
+type AuthorizedKeyOptions struct {
+	From                  []string // from="pattern-list" (CIDR/IP only; no hostname globs)
+	Command               string   // command="command"
+	Environment           map[string]string
+	PermitPTY             bool // permit-pty/no-pty; parsed but not yet enforced, see Permissions
+	PermitAgentForwarding bool
+	Expiry                time.Time // expiry-time="YYYYMMDD[HHMM[SS]]", zero if unset
+	Principals            []string  // principals="name-list"; parsed but not yet enforced, see Permissions
+}
+
+// AuthorizedKeys loads and indexes an authorized_keys file, and can be
+// embedded or wrapped by an Auth implementation to honor per-key options.
+type AuthorizedKeys struct {
+	mu      sync.RWMutex
+	entries map[string]AuthorizedKeyOptions // keyed by marshaled public key
+}
+
+// NewAuthorizedKeys loads an AuthorizedKeys from path.
+func NewAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	a := &AuthorizedKeys{}
+	if err := a.Load(path); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load (re)reads path, replacing the current set of keys.
+func (a *AuthorizedKeys) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := map[string]AuthorizedKeyOptions{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, rawOptions, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return fmt.Errorf("sshd: failed to parse authorized key: %s", err)
+		}
+		opt, err := parseKeyOptions(rawOptions)
+		if err != nil {
+			return fmt.Errorf("sshd: failed to parse options for key: %s", err)
+		}
+		entries[string(key.Marshal())] = opt
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.entries = entries
+	a.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the options recorded for key, if it is present.
+func (a *AuthorizedKeys) Lookup(key ssh.PublicKey) (AuthorizedKeyOptions, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	opt, ok := a.entries[string(key.Marshal())]
+	return opt, ok
+}
+
+// Permissions provides PermissionsAuth's method to an Auth that embeds
+// AuthorizedKeys, translating a key's authorized_keys options into
+// critical options and extensions.
+//
+// PermitPTY and Principals are not translated here: nothing in sshd yet
+// checks them against a PTY request or conn.User(), so a key carrying
+// no-pty or principals= is not actually restricted by either option.
+func (a *AuthorizedKeys) Permissions(key ssh.PublicKey) *ssh.Permissions {
+	opt, ok := a.Lookup(key)
+	if !ok {
+		return nil
+	}
+
+	perm := &ssh.Permissions{
+		CriticalOptions: map[string]string{},
+		Extensions:      map[string]string{},
+	}
+	if len(opt.From) > 0 {
+		perm.CriticalOptions["source-address"] = strings.Join(opt.From, ",")
+	}
+	if opt.Command != "" {
+		perm.CriticalOptions["force-command"] = opt.Command
+	}
+	if opt.PermitAgentForwarding {
+		perm.Extensions["permit-agent-forwarding"] = "yes"
+	}
+	if len(opt.Environment) > 0 {
+		pairs := make([]string, 0, len(opt.Environment))
+		for k, v := range opt.Environment {
+			pairs = append(pairs, k+"="+v)
+		}
+		perm.Extensions["environment"] = strings.Join(pairs, ",")
+	}
+	return perm
+}
+
+// Expired reports whether key's expiry-time option, if any, has passed.
+func (a *AuthorizedKeys) Expired(key ssh.PublicKey) bool {
+	opt, ok := a.Lookup(key)
+	if !ok || opt.Expiry.IsZero() {
+		return false
+	}
+	return time.Now().After(opt.Expiry)
+}
+
+// Watch polls path every interval and reloads the key set when its
+// modification time changes, until stop is called.
+func (a *AuthorizedKeys) Watch(path string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		var lastMod time.Time
+		if info, err := os.Stat(path); err == nil {
+			lastMod = info.ModTime()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil || !info.ModTime().After(lastMod) {
+					continue
+				}
+				lastMod = info.ModTime()
+				a.Load(path)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// parseKeyOptions parses the authorized_keys option strings returned
+// alongside a key by ssh.ParseAuthorizedKey.
+func parseKeyOptions(options []string) (AuthorizedKeyOptions, error) {
+	opt := AuthorizedKeyOptions{Environment: map[string]string{}}
+	for _, o := range options {
+		name, value := o, ""
+		if i := strings.Index(o, "="); i >= 0 {
+			name, value = o[:i], strings.Trim(o[i+1:], `"`)
+		}
+		switch name {
+		case "from":
+			opt.From = strings.Split(value, ",")
+		case "command":
+			opt.Command = value
+		case "environment":
+			if kv := strings.SplitN(value, "=", 2); len(kv) == 2 {
+				opt.Environment[kv[0]] = kv[1]
+			}
+		case "permit-pty":
+			opt.PermitPTY = true
+		case "no-pty":
+			opt.PermitPTY = false
+		case "permit-agent-forwarding":
+			opt.PermitAgentForwarding = true
+		case "no-agent-forwarding":
+			opt.PermitAgentForwarding = false
+		case "principals":
+			opt.Principals = strings.Split(value, ",")
+		case "expiry-time":
+			t, err := parseExpiryTime(value)
+			if err != nil {
+				return opt, err
+			}
+			opt.Expiry = t
+		}
+	}
+	return opt, nil
+}
+
+// parseExpiryTime parses the sshd(8) expiry-time format: YYYYMMDD or
+// YYYYMMDDHHMM[SS].
+func parseExpiryTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(value) == len(layout) {
+			return time.ParseInLocation(layout, value, time.Local)
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry-time %q", value)
+}
@@ -0,0 +1,150 @@
+package sshd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func writeAuthorizedKeys(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func authorizedKeyLine(t *testing.T, key ssh.PublicKey, options string) string {
+	t.Helper()
+	marshaled := string(ssh.MarshalAuthorizedKey(key))
+	// ssh.MarshalAuthorizedKey already appends a trailing newline; trim it
+	// so the caller's own line joining doesn't double up.
+	marshaled = marshaled[:len(marshaled)-1]
+	if options == "" {
+		return marshaled
+	}
+	return options + " " + marshaled
+}
+
+func TestParseKeyOptionsQuotedValues(t *testing.T) {
+	opt, err := parseKeyOptions([]string{
+		`command="echo hello world"`,
+		`environment="PATH=/usr/bin"`,
+	})
+	if err != nil {
+		t.Fatalf("expected quoted options to parse, got: %s", err)
+	}
+	if opt.Command != "echo hello world" {
+		t.Fatalf("expected command to keep its embedded spaces, got %q", opt.Command)
+	}
+	if opt.Environment["PATH"] != "/usr/bin" {
+		t.Fatalf("expected PATH to be set from the quoted environment option, got %q", opt.Environment["PATH"])
+	}
+}
+
+func TestParseKeyOptionsMultipleEnvironment(t *testing.T) {
+	opt, err := parseKeyOptions([]string{
+		`environment="FOO=bar"`,
+		`environment="BAZ=qux"`,
+	})
+	if err != nil {
+		t.Fatalf("expected multiple environment= options to parse, got: %s", err)
+	}
+	if opt.Environment["FOO"] != "bar" || opt.Environment["BAZ"] != "qux" {
+		t.Fatalf("expected both environment= options to be recorded, got %#v", opt.Environment)
+	}
+}
+
+func TestParseKeyOptionsMalformedExpiryTime(t *testing.T) {
+	if _, err := parseKeyOptions([]string{`expiry-time="not-a-date"`}); err == nil {
+		t.Fatal("expected a malformed expiry-time to be rejected")
+	}
+	if _, err := parseKeyOptions([]string{`expiry-time="20241301"`}); err == nil {
+		t.Fatal("expected an out-of-range expiry-time to be rejected")
+	}
+}
+
+func TestAuthorizedKeysFromMatchesAddress(t *testing.T) {
+	key := newTestKey(t)
+	path := writeAuthorizedKeys(t, authorizedKeyLine(t, key, `from="10.0.0.0/24"`))
+
+	a, err := NewAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perm := a.Permissions(key)
+	if perm.CriticalOptions["source-address"] != "10.0.0.0/24" {
+		t.Fatalf("expected source-address to carry the from= pattern, got %q", perm.CriticalOptions["source-address"])
+	}
+	if err := checkSourceAddress(perm.CriticalOptions["source-address"], fakeAddr("10.0.0.5:22")); err != nil {
+		t.Fatalf("expected an address inside the from= CIDR to be permitted, got: %s", err)
+	}
+	if err := checkSourceAddress(perm.CriticalOptions["source-address"], fakeAddr("10.0.1.5:22")); err == nil {
+		t.Fatal("expected an address outside the from= CIDR to be rejected")
+	}
+}
+
+func TestExpiredBeforeAndAfterBoundary(t *testing.T) {
+	key := newTestKey(t)
+	future := time.Now().Add(time.Hour).Format("20060102150405")
+	path := writeAuthorizedKeys(t, authorizedKeyLine(t, key, `expiry-time="`+future+`"`))
+
+	a, err := NewAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Expired(key) {
+		t.Fatal("expected a key with a future expiry-time to not be expired yet")
+	}
+
+	past := time.Now().Add(-time.Hour).Format("20060102150405")
+	path = writeAuthorizedKeys(t, authorizedKeyLine(t, key, `expiry-time="`+past+`"`))
+	if err := a.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Expired(key) {
+		t.Fatal("expected a key with a past expiry-time to be expired")
+	}
+}
+
+func TestAuthorizedKeysWatchReloadsOnChange(t *testing.T) {
+	keyA := newTestKey(t)
+	keyB := newTestKey(t)
+	path := writeAuthorizedKeys(t, authorizedKeyLine(t, keyA, ""))
+
+	a, err := NewAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Lookup(keyB); ok {
+		t.Fatal("expected keyB to not be present before the file is rewritten")
+	}
+
+	stop := a.Watch(path, 10*time.Millisecond)
+	defer stop()
+
+	// Give the file a distinct, later modification time so the poll
+	// notices the change even on filesystems with coarse mtimes.
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(authorizedKeyLine(t, keyB, "")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := a.Lookup(keyB); ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected Watch to reload the file after it was modified")
+}
@@ -0,0 +1,205 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CertAuth is implemented by Auth implementations that want to accept
+// OpenSSH user certificates, signed by a trusted CA, in addition to raw
+// public keys.
+type CertAuth interface {
+	Auth
+	// TrustedCAs returns the set of CA keys certificates are checked
+	// against. May be nil or empty to accept no certificates.
+	TrustedCAs() *TrustedCAs
+}
+
+// TrustedCAs holds the set of CA public keys that signed user certificates
+// are trusted against, and can be reloaded from disk.
+type TrustedCAs struct {
+	mu   sync.RWMutex
+	keys []ssh.PublicKey
+}
+
+// NewTrustedCAs loads a TrustedCAs from an authorized_keys-formatted file
+// of CA public keys.
+func NewTrustedCAs(path string) (*TrustedCAs, error) {
+	cas := &TrustedCAs{}
+	if err := cas.Load(path); err != nil {
+		return nil, err
+	}
+	return cas, nil
+}
+
+// Load (re)reads the CA keys from path, replacing the current set.
+func (t *TrustedCAs) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var keys []ssh.PublicKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return fmt.Errorf("sshd: failed to parse trusted CA key: %s", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.keys = keys
+	t.mu.Unlock()
+	return nil
+}
+
+// Watch polls path every interval and reloads the CA key set when its
+// modification time changes, until stop is called. Mirrors
+// AuthorizedKeys.Watch, for the same "reload without restart" reason.
+func (t *TrustedCAs) Watch(path string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		var lastMod time.Time
+		if info, err := os.Stat(path); err == nil {
+			lastMod = info.ModTime()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil || !info.ModTime().After(lastMod) {
+					continue
+				}
+				lastMod = info.ModTime()
+				t.Load(path)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// IsAuthority reports whether key is one of the trusted CA keys. It
+// satisfies ssh.CertChecker.IsUserAuthority.
+func (t *TrustedCAs) IsAuthority(key ssh.PublicKey) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	marshaled := key.Marshal()
+	for _, ca := range t.keys {
+		if bytes.Equal(ca.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCert verifies that cert is a user certificate signed by a trusted
+// CA, within its validity window, and permitted for conn.User(). It uses
+// ssh.CertChecker.Authenticate, not CheckCert, so IsUserAuthority is
+// actually consulted; CheckCert alone never checks the signing authority.
+// On success it enforces the cert's critical options and returns the
+// Permissions to grant.
+func checkCert(auth Auth, conn ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	certAuth, ok := auth.(CertAuth)
+	if !ok {
+		return nil, fmt.Errorf("sshd: certificate authentication not supported")
+	}
+	if cert.CertType == ssh.HostCert {
+		return nil, fmt.Errorf("sshd: host certificates are not valid for user authentication")
+	}
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority:          certAuth.TrustedCAs().IsAuthority,
+		SupportedCriticalOptions: []string{"force-command"},
+	}
+	if _, err := checker.Authenticate(conn, cert); err != nil {
+		return nil, err
+	}
+	if err := checkSourceAddress(cert.CriticalOptions["source-address"], conn.RemoteAddr()); err != nil {
+		return nil, err
+	}
+
+	// Still run the normal Check against the underlying key, so per-user
+	// bans and the like apply to the certificate's identity.
+	ok, err := auth.Check(conn.RemoteAddr(), cert.Key)
+	if !ok {
+		return nil, err
+	}
+
+	perm := &ssh.Permissions{
+		Extensions: map[string]string{
+			"pubkey": string(cert.Key.Marshal()),
+		},
+	}
+	if cert.KeyId != "" {
+		perm.Extensions["cert-key-id"] = cert.KeyId
+	}
+	if len(cert.ValidPrincipals) > 0 {
+		// checker.Authenticate already confirmed conn.User() is one of
+		// cert.ValidPrincipals; use it rather than ValidPrincipals[0], which
+		// may be a different principal than the one the client authenticated
+		// as.
+		perm.Extensions["cert-principal"] = conn.User()
+	}
+	if _, ok := cert.Extensions["permit-agent-forwarding"]; ok {
+		perm.Extensions["permit-agent-forwarding"] = "yes"
+	}
+	if cmd, ok := cert.CriticalOptions["force-command"]; ok {
+		perm.CriticalOptions = map[string]string{"force-command": cmd}
+	}
+	return perm, nil
+}
+
+// checkSourceAddress enforces a "source-address" critical option, a
+// comma-separated list of CIDRs/IPs, against addr. Unlike OpenSSH,
+// hostname globs (e.g. "*.example.com") are not matched.
+func checkSourceAddress(patterns string, addr net.Addr) error {
+	if patterns == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("sshd: could not parse remote address %q", addr)
+	}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+			if ipnet.Contains(ip) {
+				return nil
+			}
+			continue
+		}
+		if single := net.ParseIP(pattern); single != nil && single.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("sshd: remote address %s not permitted by source-address", host)
+}
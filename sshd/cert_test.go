@@ -0,0 +1,203 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeConn is a minimal ssh.ConnMetadata for exercising checkCert.
+type fakeConn struct {
+	user string
+	addr net.Addr
+}
+
+func (f *fakeConn) User() string          { return f.user }
+func (f *fakeConn) SessionID() []byte     { return []byte("session") }
+func (f *fakeConn) ClientVersion() []byte { return []byte("client") }
+func (f *fakeConn) ServerVersion() []byte { return []byte("server") }
+func (f *fakeConn) RemoteAddr() net.Addr  { return f.addr }
+func (f *fakeConn) LocalAddr() net.Addr   { return f.addr }
+
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "tcp" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// certAuth is a minimal CertAuth for tests.
+type certAuth struct {
+	allow bool
+	cas   *TrustedCAs
+}
+
+func (a *certAuth) AllowAnonymous() bool { return false }
+func (a *certAuth) Check(net.Addr, ssh.PublicKey) (bool, error) {
+	if !a.allow {
+		return false, errors.New("access denied")
+	}
+	return true, nil
+}
+func (a *certAuth) TrustedCAs() *TrustedCAs { return a.cas }
+
+// newTestCA generates a new CA signer and writes its public key to an
+// authorized_keys-formatted file, returning a TrustedCAs loaded from it.
+func newTestCA(t *testing.T) (ssh.Signer, *TrustedCAs) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trusted_cas")
+	line := ssh.MarshalAuthorizedKey(signer.PublicKey())
+	if err := os.WriteFile(path, line, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cas, err := NewTrustedCAs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, cas
+}
+
+// newTestCert generates a fresh user key and returns a certificate for it,
+// signed by ca, with the given principals and critical options.
+func newTestCert(t *testing.T, ca ssh.Signer, principals []string, critOpts map[string]string) *ssh.Certificate {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             sshPub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: principals,
+		ValidAfter:      0,
+		ValidBefore:     uint64(ssh.CertTimeInfinity),
+		Permissions: ssh.Permissions{
+			CriticalOptions: critOpts,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCheckCertUntrustedCARejected(t *testing.T) {
+	_, trusted := newTestCA(t)
+	attacker, _ := newTestCA(t) // signs with a key that is not in "trusted"
+
+	cert := newTestCert(t, attacker, []string{"alice"}, nil)
+	auth := &certAuth{allow: true, cas: trusted}
+	conn := &fakeConn{user: "alice", addr: fakeAddr("10.0.0.1:22")}
+
+	if _, err := checkCert(auth, conn, cert); err == nil {
+		t.Fatal("expected certificate signed by an untrusted CA to be rejected")
+	}
+}
+
+func TestCheckCertTrustedCAAccepted(t *testing.T) {
+	ca, trusted := newTestCA(t)
+	cert := newTestCert(t, ca, []string{"alice"}, nil)
+	auth := &certAuth{allow: true, cas: trusted}
+	conn := &fakeConn{user: "alice", addr: fakeAddr("10.0.0.1:22")}
+
+	perm, err := checkCert(auth, conn, cert)
+	if err != nil {
+		t.Fatalf("expected certificate signed by a trusted CA to be accepted, got: %s", err)
+	}
+	if perm.Extensions["cert-principal"] != "alice" {
+		t.Fatalf("expected cert-principal extension to be set, got %q", perm.Extensions["cert-principal"])
+	}
+}
+
+func TestCheckCertPrincipalMatchesAuthenticatedUser(t *testing.T) {
+	ca, trusted := newTestCA(t)
+	cert := newTestCert(t, ca, []string{"root", "alice"}, nil)
+	auth := &certAuth{allow: true, cas: trusted}
+	conn := &fakeConn{user: "alice", addr: fakeAddr("10.0.0.1:22")}
+
+	perm, err := checkCert(auth, conn, cert)
+	if err != nil {
+		t.Fatalf("expected alice to be accepted as one of the cert's principals, got: %s", err)
+	}
+	if perm.Extensions["cert-principal"] != "alice" {
+		t.Fatalf("expected cert-principal to be the authenticated user alice, got %q", perm.Extensions["cert-principal"])
+	}
+}
+
+func TestCheckCertWrongPrincipalRejected(t *testing.T) {
+	ca, trusted := newTestCA(t)
+	cert := newTestCert(t, ca, []string{"bob"}, nil)
+	auth := &certAuth{allow: true, cas: trusted}
+	conn := &fakeConn{user: "alice", addr: fakeAddr("10.0.0.1:22")}
+
+	if _, err := checkCert(auth, conn, cert); err == nil {
+		t.Fatal("expected certificate not valid for alice to be rejected")
+	}
+}
+
+func TestTrustedCAsWatchReloadsOnChange(t *testing.T) {
+	caA, trusted := newTestCA(t)
+	caB, _ := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "trusted_cas")
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(caA.PublicKey()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := trusted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if trusted.IsAuthority(caB.PublicKey()) {
+		t.Fatal("expected caB to not be trusted before the file is rewritten")
+	}
+
+	stop := trusted.Watch(path, 10*time.Millisecond)
+	defer stop()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(caB.PublicKey()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if trusted.IsAuthority(caB.PublicKey()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected Watch to reload the file after it was modified")
+}
+
+func TestCheckCertForceCommandEnforced(t *testing.T) {
+	ca, trusted := newTestCA(t)
+	cert := newTestCert(t, ca, []string{"alice"}, map[string]string{"force-command": "/bin/true"})
+	auth := &certAuth{allow: true, cas: trusted}
+	conn := &fakeConn{user: "alice", addr: fakeAddr("10.0.0.1:22")}
+
+	perm, err := checkCert(auth, conn, cert)
+	if err != nil {
+		t.Fatalf("expected certificate with a force-command critical option to be accepted, got: %s", err)
+	}
+	if perm.CriticalOptions["force-command"] != "/bin/true" {
+		t.Fatalf("expected force-command to be recorded, got %q", perm.CriticalOptions["force-command"])
+	}
+}
@@ -0,0 +1,80 @@
+package sshd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SecondFactor is an additional authentication factor, enrolled per user
+// and challenged after public key authentication has already succeeded.
+type SecondFactor interface {
+	// Enrolled reports whether user has a second factor configured. Users
+	// that aren't enrolled are left alone, so operators can require MFA
+	// for specific accounts without locking out everyone else.
+	Enrolled(user string) bool
+	// Challenge returns the prompts to present to user, and a verify
+	// function that checks the corresponding answers.
+	Challenge(user string) (prompts []string, verify func(answers []string) bool)
+}
+
+// MFAAuth is implemented by Auth implementations that require a second
+// factor, via SecondFactor, for specific users.
+//
+// The second factor is not enforced inside the auth exchange itself.
+// golang.org/x/crypto/ssh has no server-side "partial success" mechanism,
+// and its ServerConn caches PublicKeyCallback's result per (user, key) for
+// the life of a connection (see pubKeyCache in its server.go), calling the
+// callback only once per key no matter how many times the client offers
+// it. A default-configured client tries publickey before
+// keyboard-interactive, so a chain that gated PublicKeyCallback behind a
+// prior keyboard-interactive pass would get that first, pre-2FA publickey
+// attempt rejected and cached — and the client's later retry of the same
+// key, after completing keyboard-interactive, would just replay the
+// cached rejection without the callback ever running again, leaving such
+// a client permanently unable to log in.
+//
+// Instead, public key authentication for an MFA-enrolled user succeeds
+// normally, and MakeAuth marks the resulting Permissions with
+// MFARequired. The session layer is expected to check MFARequired and, if
+// set, call ChallengeSecondFactor over the session's channel before
+// servicing any request on it.
+type MFAAuth interface {
+	Auth
+	SecondFactor() SecondFactor
+}
+
+// MFARequired reports whether perm belongs to a connection that must
+// complete ChallengeSecondFactor before the session layer services any
+// request on it, as set by MakeAuth for an MFA-enrolled user.
+func MFARequired(perm *ssh.Permissions) bool {
+	return perm != nil && perm.Extensions["mfa-required"] == "1"
+}
+
+// ChallengeSecondFactor runs sf's challenge for user over rw — typically
+// the session channel, before any shell, exec, or other request on it is
+// serviced — writing each prompt and reading a newline-terminated answer.
+// It returns an error if rw fails or the answers don't verify; the caller
+// must close the connection in that case rather than grant the session.
+func ChallengeSecondFactor(sf SecondFactor, user string, rw io.ReadWriter) error {
+	prompts, verify := sf.Challenge(user)
+	reader := bufio.NewReader(rw)
+	answers := make([]string, len(prompts))
+	for i, prompt := range prompts {
+		if _, err := io.WriteString(rw, prompt); err != nil {
+			return err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		answers[i] = strings.TrimRight(line, "\r\n")
+	}
+	if !verify(answers) {
+		return fmt.Errorf("sshd: second factor verification failed")
+	}
+	return nil
+}
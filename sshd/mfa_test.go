@@ -0,0 +1,139 @@
+package sshd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMFARequired(t *testing.T) {
+	if MFARequired(nil) {
+		t.Fatal("expected a nil Permissions to not require a second factor")
+	}
+	if MFARequired(&ssh.Permissions{}) {
+		t.Fatal("expected Permissions with no mfa-required extension to not require a second factor")
+	}
+	perm := &ssh.Permissions{Extensions: map[string]string{"mfa-required": "1"}}
+	if !MFARequired(perm) {
+		t.Fatal("expected Permissions with mfa-required=1 to require a second factor")
+	}
+}
+
+// TestMakeAuthMarksMFARequired checks that PublicKeyCallback grants
+// Permissions normally for an MFA-enrolled user — it must not block on the
+// second factor inside the auth exchange, since the session layer is
+// responsible for that — but marks the result so the session layer knows
+// one is still owed.
+func TestMakeAuthMarksMFARequired(t *testing.T) {
+	totp := NewTOTPSecondFactor()
+	totp.SetSecret("alice", []byte("12345678901234567890"))
+	auth := &mfaStubAuth{sf: totp}
+	config := MakeAuth(auth)
+
+	key := newTestKey(t)
+	conn := &fakeConn{user: "alice", addr: fakeAddr("10.0.0.1:22")}
+
+	perm, err := config.PublicKeyCallback(conn, key)
+	if err != nil {
+		t.Fatalf("expected publickey to be granted for an MFA-enrolled user, got: %s", err)
+	}
+	if !MFARequired(perm) {
+		t.Fatal("expected mfa-required to be set for an enrolled user")
+	}
+
+	bob := &fakeConn{user: "bob", addr: fakeAddr("10.0.0.1:22")}
+	perm, err = config.PublicKeyCallback(bob, newTestKey(t))
+	if err != nil {
+		t.Fatalf("expected publickey to be granted for an unenrolled user, got: %s", err)
+	}
+	if MFARequired(perm) {
+		t.Fatal("expected mfa-required to not be set for an unenrolled user")
+	}
+}
+
+func TestChallengeSecondFactor(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	totp := NewTOTPSecondFactor()
+	totp.SetSecret("alice", secret)
+
+	step := time.Now().Unix() / int64(totpStep.Seconds())
+	code := generateTOTP(secret, step)
+
+	var rw bytes.Buffer
+	rw.WriteString(code + "\n")
+	if err := ChallengeSecondFactor(totp, "alice", &rw); err != nil {
+		t.Fatalf("expected the right code to verify, got: %s", err)
+	}
+
+	rw.Reset()
+	rw.WriteString("000000\n")
+	if err := ChallengeSecondFactor(totp, "alice", &rw); err == nil {
+		t.Fatal("expected a wrong code to fail verification")
+	}
+}
+
+func TestTOTPSetBase32Secret(t *testing.T) {
+	totp := NewTOTPSecondFactor()
+
+	// Generated with the raw secret "12345678901234567890", as an
+	// operator would paste it from an otpauth:// URI or enrollment QR
+	// code, lowercase and unpadded.
+	if err := totp.SetBase32Secret("alice", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq"); err != nil {
+		t.Fatalf("expected a valid base32 secret to be accepted, got: %s", err)
+	}
+	if !totp.Enrolled("alice") {
+		t.Fatal("expected alice to be enrolled after SetBase32Secret")
+	}
+
+	code := generateTOTP([]byte("12345678901234567890"), time.Now().Unix()/int64(totpStep.Seconds()))
+	_, verify := totp.Challenge("alice")
+	if !verify([]string{code}) {
+		t.Fatal("expected the decoded secret to validate the same codes as the raw one")
+	}
+
+	if err := totp.SetBase32Secret("bob", "not valid base32!!"); err == nil {
+		t.Fatal("expected an invalid base32 secret to be rejected")
+	}
+}
+
+func TestTOTPEnrolled(t *testing.T) {
+	totp := NewTOTPSecondFactor()
+
+	if totp.Enrolled("alice") {
+		t.Fatal("expected a fresh user to not be enrolled")
+	}
+	totp.SetSecret("alice", []byte("12345678901234567890"))
+	if !totp.Enrolled("alice") {
+		t.Fatal("expected user to be enrolled after SetSecret")
+	}
+	totp.SetSecret("alice", nil)
+	if totp.Enrolled("alice") {
+		t.Fatal("expected user to no longer be enrolled after removal")
+	}
+}
+
+type mfaStubAuth struct {
+	sf SecondFactor
+}
+
+func (a *mfaStubAuth) AllowAnonymous() bool                        { return false }
+func (a *mfaStubAuth) Check(net.Addr, ssh.PublicKey) (bool, error) { return true, nil }
+func (a *mfaStubAuth) SecondFactor() SecondFactor                  { return a.sf }
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}